@@ -0,0 +1,123 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultLastUsedFlushInterval = time.Minute
+	defaultLastUsedFlushJitter   = 10 * time.Second
+	defaultLastUsedCacheSize     = 10000
+)
+
+// lastUsedFlusher decides when the last-used timestamp of an API key should
+// actually be propagated to the shared cache/datastore. It keeps a bounded,
+// in-process LRU of the last flush time of every key seen recently so that a
+// hot key only causes a flush once per flushInterval (plus jitter), instead
+// of on every single Verify call.
+type lastUsedFlusher struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+
+	maxEntries    int
+	flushInterval time.Duration
+	flushJitter   time.Duration
+
+	hits    uint64
+	misses  uint64
+	flushes uint64
+}
+
+type lastUsedEntry struct {
+	keyID      string
+	flushAfter time.Time
+}
+
+func newLastUsedFlusher(maxEntries int, flushInterval, flushJitter time.Duration) *lastUsedFlusher {
+	return &lastUsedFlusher{
+		ll:            list.New(),
+		items:         make(map[string]*list.Element),
+		maxEntries:    maxEntries,
+		flushInterval: flushInterval,
+		flushJitter:   flushJitter,
+	}
+}
+
+// shouldFlush reports whether keyID's last-used timestamp is stale enough to
+// be flushed at now. When it returns true, it also records that a flush is
+// in-flight so calls for the same key within the next flushInterval (+
+// jitter) are suppressed.
+func (f *lastUsedFlusher) shouldFlush(keyID string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if e, ok := f.items[keyID]; ok {
+		f.ll.MoveToFront(e)
+		entry := e.Value.(*lastUsedEntry)
+		if now.Before(entry.flushAfter) {
+			atomic.AddUint64(&f.hits, 1)
+			return false
+		}
+		entry.flushAfter = f.nextFlushAfter(now)
+		atomic.AddUint64(&f.flushes, 1)
+		return true
+	}
+
+	atomic.AddUint64(&f.misses, 1)
+	e := f.ll.PushFront(&lastUsedEntry{
+		keyID:      keyID,
+		flushAfter: f.nextFlushAfter(now),
+	})
+	f.items[keyID] = e
+	atomic.AddUint64(&f.flushes, 1)
+
+	if f.ll.Len() > f.maxEntries {
+		f.evictOldestLocked()
+	}
+	return true
+}
+
+// nextFlushAfter returns the next time at which keyID may be flushed again,
+// jittered so that replicas sharing the same flushInterval don't all flush
+// the same hot keys at the same time.
+func (f *lastUsedFlusher) nextFlushAfter(now time.Time) time.Time {
+	jitter := time.Duration(0)
+	if f.flushJitter > 0 {
+		jitter = time.Duration(rand.Int63n(int64(f.flushJitter)))
+	}
+	return now.Add(f.flushInterval + jitter)
+}
+
+func (f *lastUsedFlusher) evictOldestLocked() {
+	e := f.ll.Back()
+	if e == nil {
+		return
+	}
+	f.ll.Remove(e)
+	delete(f.items, e.Value.(*lastUsedEntry).keyID)
+}
+
+// stats returns the cumulative hit/miss/flush counters so that callers can
+// expose them as metrics.
+func (f *lastUsedFlusher) stats() (hits, misses, flushes uint64) {
+	return atomic.LoadUint64(&f.hits), atomic.LoadUint64(&f.misses), atomic.LoadUint64(&f.flushes)
+}
@@ -0,0 +1,58 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastUsedFlusherShouldFlush(t *testing.T) {
+	f := newLastUsedFlusher(10, time.Minute, 0)
+	now := time.Now()
+
+	assert.True(t, f.shouldFlush("key-1", now), "first sighting of a key should always flush")
+	assert.False(t, f.shouldFlush("key-1", now.Add(30*time.Second)), "a key within flushInterval should be suppressed")
+	assert.True(t, f.shouldFlush("key-1", now.Add(time.Minute+time.Second)), "a key past flushInterval should flush again")
+}
+
+func TestLastUsedFlusherEvictsOldest(t *testing.T) {
+	f := newLastUsedFlusher(2, time.Minute, 0)
+	now := time.Now()
+
+	f.shouldFlush("key-1", now)
+	f.shouldFlush("key-2", now)
+	f.shouldFlush("key-3", now)
+
+	assert.Equal(t, 2, f.ll.Len(), "cache should never grow past maxEntries")
+	_, ok := f.items["key-1"]
+	assert.False(t, ok, "the least recently used key should have been evicted")
+}
+
+func TestLastUsedFlusherStats(t *testing.T) {
+	f := newLastUsedFlusher(10, time.Minute, 0)
+	now := time.Now()
+
+	f.shouldFlush("key-1", now)
+	f.shouldFlush("key-1", now.Add(time.Second))
+	f.shouldFlush("key-2", now)
+
+	hits, misses, flushes := f.stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(2), misses)
+	assert.Equal(t, uint64(2), flushes)
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultNegativeCacheTTL    = 30 * time.Second
+	defaultNegativeCacheJitter = 10 * time.Second
+	defaultNegativeCacheSize   = 10000
+)
+
+// negativeCache remembers, for a short and jittered amount of time, API key
+// IDs that are known not to exist in the datastore. It protects the
+// datastore from being repeatedly probed by attackers or misconfigured
+// clients sending invalid key IDs.
+type negativeCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+
+	maxEntries int
+	ttl        time.Duration
+	jitter     time.Duration
+
+	hits uint64
+}
+
+type negativeCacheEntry struct {
+	keyID     string
+	expiresAt time.Time
+}
+
+func newNegativeCache(maxEntries int, ttl, jitter time.Duration) *negativeCache {
+	return &negativeCache{
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		jitter:     jitter,
+	}
+}
+
+// Contains reports whether keyID is currently remembered as not found.
+func (c *negativeCache) Contains(keyID string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[keyID]
+	if !ok {
+		return false
+	}
+	entry := e.Value.(*negativeCacheEntry)
+	if now.After(entry.expiresAt) {
+		c.ll.Remove(e)
+		delete(c.items, keyID)
+		return false
+	}
+	c.ll.MoveToFront(e)
+	atomic.AddUint64(&c.hits, 1)
+	return true
+}
+
+// Add remembers keyID as not found for ttl (plus jitter).
+func (c *negativeCache) Add(keyID string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	jitter := time.Duration(0)
+	if c.jitter > 0 {
+		jitter = time.Duration(rand.Int63n(int64(c.jitter)))
+	}
+
+	if e, ok := c.items[keyID]; ok {
+		e.Value.(*negativeCacheEntry).expiresAt = now.Add(c.ttl + jitter)
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	e := c.ll.PushFront(&negativeCacheEntry{
+		keyID:     keyID,
+		expiresAt: now.Add(c.ttl + jitter),
+	})
+	c.items[keyID] = e
+
+	if c.ll.Len() > c.maxEntries {
+		if back := c.ll.Back(); back != nil {
+			c.ll.Remove(back)
+			delete(c.items, back.Value.(*negativeCacheEntry).keyID)
+		}
+	}
+}
+
+// hitCount returns the cumulative number of negative cache hits.
+func (c *negativeCache) hitCount() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegativeCacheContainsExpiry(t *testing.T) {
+	c := newNegativeCache(10, 30*time.Second, 0)
+	now := time.Now()
+
+	assert.False(t, c.Contains("key-1", now), "an unseen key should not be found")
+
+	c.Add("key-1", now)
+	assert.True(t, c.Contains("key-1", now), "a just-added key should be found")
+	assert.True(t, c.Contains("key-1", now.Add(29*time.Second)), "a key within ttl should still be found")
+	assert.False(t, c.Contains("key-1", now.Add(31*time.Second)), "a key past ttl should have expired")
+}
+
+func TestNegativeCacheAddRefreshesTTL(t *testing.T) {
+	c := newNegativeCache(10, 30*time.Second, 0)
+	now := time.Now()
+
+	c.Add("key-1", now)
+	c.Add("key-1", now.Add(20*time.Second))
+
+	assert.True(t, c.Contains("key-1", now.Add(40*time.Second)), "re-adding a key should push out its expiry")
+}
+
+func TestNegativeCacheEvictsOldest(t *testing.T) {
+	c := newNegativeCache(2, time.Minute, 0)
+	now := time.Now()
+
+	c.Add("key-1", now)
+	c.Add("key-2", now)
+	c.Add("key-3", now)
+
+	assert.Equal(t, 2, c.ll.Len(), "cache should never grow past maxEntries")
+	assert.False(t, c.Contains("key-1", now), "the least recently used key should have been evicted")
+}
+
+func TestNegativeCacheHitCount(t *testing.T) {
+	c := newNegativeCache(10, time.Minute, 0)
+	now := time.Now()
+
+	c.Add("key-1", now)
+	c.Contains("key-1", now)
+	c.Contains("key-1", now)
+	c.Contains("key-2", now)
+
+	assert.Equal(t, uint64(2), c.hitCount())
+}
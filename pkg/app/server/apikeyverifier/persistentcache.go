@@ -0,0 +1,249 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+
+	"github.com/pipe-cd/pipecd/pkg/model"
+)
+
+// persistentCacheAPIVersion/Kind identify the on-disk format of the
+// persistent API key cache file, so that a future, incompatible format can
+// be safely rejected instead of being misread.
+const (
+	persistentCacheAPIVersion = "pipecd.dev/v1beta1"
+	persistentCacheKind       = "APIKeyCache"
+
+	defaultMaxCacheDuration = time.Hour
+)
+
+// errUnsupportedVersion is returned when a persistent cache file was
+// written by a format this version of piped doesn't understand.
+var errUnsupportedVersion = errors.New("apikeyverifier: unsupported persistent cache version")
+
+// persistentCacheFile is the on-disk representation of a piped's API key
+// cache, allowing it to warm-start its in-memory cache across restarts
+// instead of causing a thundering herd against the control-plane datastore.
+type persistentCacheFile struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Entries    []persistentCacheEntry `json:"entries"`
+}
+
+type persistentCacheEntry struct {
+	KeyID             string          `json:"keyId"`
+	APIKey            json.RawMessage `json:"apiKey"`
+	CreationTimestamp time.Time       `json:"creationTimestamp"`
+	LastUsedTimestamp time.Time       `json:"lastUsedTimestamp"`
+	ExpiresAt         time.Time       `json:"expiresAt"`
+}
+
+// persistentCache is a piped-side, on-disk mirror of the verified API keys,
+// written asynchronously so that a piped restart can warm-start its
+// in-memory cache instead of causing a thundering herd against the
+// control-plane datastore at rollout time.
+//
+// Writes are serialized through a single background flushLoop goroutine
+// instead of one goroutine per touch: touch only marks the cache dirty and
+// nudges flushSignal, so a hot key can never spawn more than one flush at a
+// time, and concurrent flushes can never race on the same temp file.
+type persistentCache struct {
+	mu      sync.Mutex
+	entries map[string]persistentCacheEntry
+
+	path             string
+	ttl              time.Duration
+	maxCacheDuration time.Duration
+	maxEntries       int
+
+	flushSignal chan struct{}
+	closed      chan struct{}
+
+	logger *zap.Logger
+}
+
+func newPersistentCache(path string, ttl, maxCacheDuration time.Duration, maxEntries int, logger *zap.Logger) *persistentCache {
+	if maxCacheDuration <= 0 {
+		maxCacheDuration = defaultMaxCacheDuration
+	}
+	c := &persistentCache{
+		entries:          make(map[string]persistentCacheEntry),
+		path:             path,
+		ttl:              ttl,
+		maxCacheDuration: maxCacheDuration,
+		maxEntries:       maxEntries,
+		flushSignal:      make(chan struct{}, 1),
+		closed:           make(chan struct{}),
+		logger:           logger,
+	}
+	go c.flushLoop()
+	return c
+}
+
+// Close stops the background flush loop. It does not flush pending writes.
+func (c *persistentCache) Close() {
+	close(c.closed)
+}
+
+// flushLoop is the single writer of the cache file: it wakes up whenever
+// touch signals a dirty cache and debounces any further signals that
+// arrive while a flush is already in flight.
+func (c *persistentCache) flushLoop() {
+	for {
+		select {
+		case <-c.flushSignal:
+			c.flush(time.Now())
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// load reads the cache file from disk, keeps only the entries that are
+// still usable (not expired, and not older than maxCacheDuration), and
+// returns them so the caller can warm its in-memory cache with them.
+func (c *persistentCache) load(now time.Time) ([]persistentCacheEntry, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read persistent API key cache file %s: %w", c.path, err)
+	}
+
+	var file persistentCacheFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse persistent API key cache file %s: %w", c.path, err)
+	}
+	if file.APIVersion != persistentCacheAPIVersion || file.Kind != persistentCacheKind {
+		return nil, errUnsupportedVersion
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	valid := make([]persistentCacheEntry, 0, len(file.Entries))
+	for _, e := range file.Entries {
+		if now.After(e.ExpiresAt) || now.Sub(e.CreationTimestamp) >= c.maxCacheDuration {
+			continue
+		}
+		c.entries[e.KeyID] = e
+		valid = append(valid, e)
+	}
+	return valid, nil
+}
+
+// touch records that keyID was successfully verified at now, and nudges
+// flushLoop to rewrite the cache file so the entry survives a restart.
+func (c *persistentCache) touch(keyID string, apiKey *model.APIKey, now time.Time) {
+	raw, err := json.Marshal(apiKey)
+	if err != nil {
+		c.logger.Warn("unable to encode API key for the persistent cache", zap.Error(err))
+		return
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[keyID]
+	if !ok {
+		entry = persistentCacheEntry{KeyID: keyID, CreationTimestamp: now}
+	}
+	entry.APIKey = raw
+	entry.LastUsedTimestamp = now
+	entry.ExpiresAt = now.Add(c.ttl)
+	c.entries[keyID] = entry
+	c.mu.Unlock()
+
+	// Non-blocking: if a flush is already pending (buffer full), this touch
+	// rides along with it instead of queuing another. While a flush is in
+	// flight and none is pending, this queues exactly one more flush.
+	select {
+	case c.flushSignal <- struct{}{}:
+	default:
+	}
+}
+
+// flush rewrites the cache file, pruning expired entries and capping the
+// total size to maxEntries (keeping the most recently used ones).
+func (c *persistentCache) flush(now time.Time) {
+	c.mu.Lock()
+	entries := make([]persistentCacheEntry, 0, len(c.entries))
+	for keyID, e := range c.entries {
+		if now.After(e.ExpiresAt) {
+			delete(c.entries, keyID)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsedTimestamp.After(entries[j].LastUsedTimestamp)
+	})
+	if c.maxEntries > 0 && len(entries) > c.maxEntries {
+		for _, e := range entries[c.maxEntries:] {
+			delete(c.entries, e.KeyID)
+		}
+		entries = entries[:c.maxEntries]
+	}
+	c.mu.Unlock()
+
+	file := persistentCacheFile{
+		APIVersion: persistentCacheAPIVersion,
+		Kind:       persistentCacheKind,
+		Entries:    entries,
+	}
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		c.logger.Warn("unable to encode persistent API key cache", zap.Error(err))
+		return
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		c.logger.Warn("unable to create persistent API key cache directory", zap.Error(err))
+		return
+	}
+
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		c.logger.Warn("unable to create temporary persistent API key cache file", zap.Error(err))
+		return
+	}
+	tmp := tmpFile.Name()
+	defer os.Remove(tmp) // no-op once the rename below succeeds
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		c.logger.Warn("unable to write persistent API key cache file", zap.String("path", tmp), zap.Error(err))
+		return
+	}
+	if err := tmpFile.Close(); err != nil {
+		c.logger.Warn("unable to close persistent API key cache file", zap.String("path", tmp), zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		c.logger.Warn("unable to replace persistent API key cache file", zap.String("path", c.path), zap.Error(err))
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+
+	"github.com/pipe-cd/pipecd/pkg/model"
+)
+
+func TestPersistentCacheLoadNonExistentFileIsEmpty(t *testing.T) {
+	c := newPersistentCache(filepath.Join(t.TempDir(), "does-not-exist.yaml"), time.Minute, time.Hour, 100, zap.NewNop())
+	defer c.Close()
+
+	entries, err := c.load(time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestPersistentCacheRejectsUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: pipecd.dev/v1alpha1
+kind: APIKeyCache
+entries: []
+`), 0600))
+
+	c := newPersistentCache(path, time.Minute, time.Hour, 100, zap.NewNop())
+	defer c.Close()
+
+	_, err := c.load(time.Now())
+	assert.ErrorIs(t, err, errUnsupportedVersion)
+}
+
+func TestPersistentCacheLoadPrunesExpiredAndStaleEntries(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+
+	file := persistentCacheFile{
+		APIVersion: persistentCacheAPIVersion,
+		Kind:       persistentCacheKind,
+		Entries: []persistentCacheEntry{
+			{KeyID: "fresh", CreationTimestamp: now, LastUsedTimestamp: now, ExpiresAt: now.Add(time.Hour)},
+			{KeyID: "expired", CreationTimestamp: now, LastUsedTimestamp: now, ExpiresAt: now.Add(-time.Minute)},
+			{KeyID: "too-old", CreationTimestamp: now.Add(-2 * time.Hour), LastUsedTimestamp: now, ExpiresAt: now.Add(time.Hour)},
+		},
+	}
+
+	data, err := yaml.Marshal(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	c := newPersistentCache(path, time.Minute, time.Hour, 100, zap.NewNop())
+	defer c.Close()
+
+	entries, err := c.load(now)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "fresh", entries[0].KeyID)
+}
+
+func TestPersistentCacheTouchAndFlushRoundTrip(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+
+	w := newPersistentCache(path, time.Minute, time.Hour, 100, zap.NewNop())
+	w.touch("key-1", &model.APIKey{Id: "key-1", ProjectId: "project-1"}, now)
+	w.flush(now)
+	w.Close()
+
+	c := newPersistentCache(path, time.Minute, time.Hour, 100, zap.NewNop())
+	defer c.Close()
+
+	entries, err := c.load(now)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "key-1", entries[0].KeyID)
+}
+
+func TestPersistentCacheFlushCapsToMaxEntries(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+
+	w := newPersistentCache(path, time.Minute, time.Hour, 1, zap.NewNop())
+	w.touch("older", &model.APIKey{Id: "older"}, now)
+	w.touch("newer", &model.APIKey{Id: "newer"}, now.Add(time.Second))
+	w.flush(now.Add(time.Second))
+	w.Close()
+
+	c := newPersistentCache(path, time.Minute, time.Hour, 1, zap.NewNop())
+	defer c.Close()
+
+	entries, err := c.load(now.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "newer", entries[0].KeyID, "flush should keep the most recently used entry when capping to maxEntries")
+}
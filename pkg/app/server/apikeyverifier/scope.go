@@ -0,0 +1,52 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikeyverifier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pipe-cd/pipecd/pkg/model"
+)
+
+// ErrInsufficientScope is returned by VerifyWithScope when key is valid but
+// does not carry the requested Scope.
+type ErrInsufficientScope struct {
+	KeyID string
+	Scope model.Scope
+}
+
+func (e *ErrInsufficientScope) Error() string {
+	return fmt.Sprintf("api key %s does not have the %s scope", e.KeyID, e.Scope)
+}
+
+// VerifyWithScope verifies key the same way Verify does, and additionally
+// requires that the key carries requiredScope.
+func (v *Verifier) VerifyWithScope(ctx context.Context, key string, requiredScope model.Scope) (*model.APIKey, error) {
+	apiKey, err := v.Verify(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !apiKey.HasScope(requiredScope) {
+		keyID, err := model.ExtractAPIKeyID(key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ErrInsufficientScope{KeyID: keyID, Scope: requiredScope}
+	}
+
+	return apiKey, nil
+}
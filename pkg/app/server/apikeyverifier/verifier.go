@@ -16,14 +16,20 @@ package apikeyverifier
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/pipe-cd/pipecd/pkg/cache"
+	"github.com/pipe-cd/pipecd/pkg/cache/memcachedcache"
 	"github.com/pipe-cd/pipecd/pkg/cache/memorycache"
 	"github.com/pipe-cd/pipecd/pkg/cache/rediscache"
+	"github.com/pipe-cd/pipecd/pkg/datastore"
 	"github.com/pipe-cd/pipecd/pkg/model"
 	"github.com/pipe-cd/pipecd/pkg/redis"
 )
@@ -34,21 +40,190 @@ type apiKeyGetter interface {
 }
 
 type Verifier struct {
-	apiKeyCache         cache.Cache
+	apiKeyCache         cache.StringCache
 	apiKeyStore         apiKeyGetter
 	apiKeyLastUsedCache cache.Cache
-	logger              *zap.Logger
+	lastUsedFlusher     *lastUsedFlusher
+	negativeCache       *negativeCache
+	persistentCache     *persistentCache
+	sfGroup             singleflight.Group
+
+	singleflightTimeout time.Duration
+
+	coalesced uint64
+
+	logger *zap.Logger
 }
 
 const apiKeyLastUsedCacheHashKey = "HASHKEY:PIPED:API_KEYS"
+const apiKeyCacheKeyPrefix = "APIKEY"
+
+const (
+	defaultSingleflightTimeout = 10 * time.Second
+	defaultCacheTTL            = 5 * time.Minute
+	defaultCacheMaxEntries     = 10000
+)
+
+// VerifierOptions configures a Verifier: which cache adapter backs the
+// positive API key cache and how its tuning knobs, as well as the
+// last-used/negative-cache/singleflight behaviors, are set. The zero value
+// is not ready to use; call DefaultVerifierOptions and override as needed.
+type VerifierOptions struct {
+	// CacheAdapter selects the backend of the positive API key cache.
+	// Defaults to cache.AdapterMemory.
+	CacheAdapter cache.Adapter
+	// CacheTTL is how long a positive API key lookup is cached.
+	// DefaultVerifierOptions sets it to 5 minutes.
+	CacheTTL time.Duration
+	// CacheMaxEntries bounds the size of the memory tier (used directly
+	// for AdapterMemory, and as the L1 size for AdapterTwoTier).
+	CacheMaxEntries int
+	// MetricsPrefix is prepended to the keys stored by the Redis, memcached
+	// and two-tier (L2) adapters; the memory adapter does not use it. The
+	// cache adapters emit no metrics under it.
+	MetricsPrefix string
+
+	// Redis is required when CacheAdapter is AdapterRedis or
+	// AdapterTwoTier. When set, it is also used for the last-used-at hash
+	// cache; when unset, that cache is skipped.
+	Redis redis.Redis
+	// MemcachedAddrs is required when CacheAdapter is AdapterMemcached.
+	MemcachedAddrs []string
+
+	LastUsedFlushInterval time.Duration
+	LastUsedFlushJitter   time.Duration
+	LastUsedCacheSize     int
+
+	NegativeCacheTTL    time.Duration
+	NegativeCacheJitter time.Duration
+	NegativeCacheSize   int
+
+	SingleflightTimeout time.Duration
+
+	// PersistentCacheFile, when set, enables a piped-side, on-disk mirror
+	// of verified API keys (typically under piped's state dir) so that a
+	// piped restart can warm-start its in-memory cache instead of
+	// stampeding the control-plane datastore.
+	PersistentCacheFile string
+	// MaxCacheDuration bounds how old a persistent cache entry may be
+	// (based on its CreationTimestamp) before it's no longer loaded on
+	// startup, regardless of its ExpiresAt. Defaults to 1 hour.
+	MaxCacheDuration time.Duration
+	// PersistentCacheMaxEntries caps the number of entries kept in the
+	// persistent cache file.
+	PersistentCacheMaxEntries int
+}
+
+// DefaultVerifierOptions returns VerifierOptions with every tuning knob set
+// to its default value. NewVerifier uses the options exactly as given and
+// does not replace zero-valued fields with these defaults.
+func DefaultVerifierOptions() VerifierOptions {
+	return VerifierOptions{
+		CacheAdapter:          cache.AdapterMemory,
+		CacheTTL:              defaultCacheTTL,
+		CacheMaxEntries:       defaultCacheMaxEntries,
+		MetricsPrefix:         "apikeyverifier",
+		LastUsedFlushInterval: defaultLastUsedFlushInterval,
+		LastUsedFlushJitter:   defaultLastUsedFlushJitter,
+		LastUsedCacheSize:     defaultLastUsedCacheSize,
+		NegativeCacheTTL:      defaultNegativeCacheTTL,
+		NegativeCacheJitter:   defaultNegativeCacheJitter,
+		NegativeCacheSize:     defaultNegativeCacheSize,
+		SingleflightTimeout:   defaultSingleflightTimeout,
+		MaxCacheDuration:      defaultMaxCacheDuration,
+	}
+}
 
-func NewVerifier(ctx context.Context, getter apiKeyGetter, rd redis.Redis, logger *zap.Logger) *Verifier {
-	return &Verifier{
-		apiKeyCache:         memorycache.NewTTLCache(ctx, 5*time.Minute, time.Minute),
+// buildAPIKeyCache constructs the positive API key cache.StringCache
+// according to opts.CacheAdapter.
+func buildAPIKeyCache(opts VerifierOptions) (cache.StringCache, error) {
+	switch opts.CacheAdapter {
+	case "", cache.AdapterMemory:
+		return memorycache.NewStringCache(opts.CacheMaxEntries, opts.CacheTTL), nil
+	case cache.AdapterRedis:
+		if opts.Redis == nil {
+			return nil, fmt.Errorf("redis client is required for the %s cache adapter", cache.AdapterRedis)
+		}
+		return rediscache.NewStringCache(opts.Redis, opts.MetricsPrefix+":"+apiKeyCacheKeyPrefix, opts.CacheTTL), nil
+	case cache.AdapterMemcached:
+		if len(opts.MemcachedAddrs) == 0 {
+			return nil, fmt.Errorf("memcached addresses are required for the %s cache adapter", cache.AdapterMemcached)
+		}
+		return memcachedcache.NewStringCache(opts.MemcachedAddrs, opts.MetricsPrefix+":"+apiKeyCacheKeyPrefix, opts.CacheTTL), nil
+	case cache.AdapterTwoTier:
+		if opts.Redis == nil {
+			return nil, fmt.Errorf("redis client is required for the %s cache adapter", cache.AdapterTwoTier)
+		}
+		l1 := memorycache.NewStringCache(opts.CacheMaxEntries, opts.CacheTTL)
+		l2 := rediscache.NewStringCache(opts.Redis, opts.MetricsPrefix+":"+apiKeyCacheKeyPrefix, opts.CacheTTL)
+		return cache.NewTwoTierCache(l1, l2), nil
+	default:
+		return nil, fmt.Errorf("unknown cache adapter %q", opts.CacheAdapter)
+	}
+}
+
+func NewVerifier(ctx context.Context, getter apiKeyGetter, logger *zap.Logger, opts VerifierOptions) (*Verifier, error) {
+	apiKeyCache, err := buildAPIKeyCache(opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build API key cache: %w", err)
+	}
+
+	v := &Verifier{
+		apiKeyCache:         apiKeyCache,
 		apiKeyStore:         getter,
-		apiKeyLastUsedCache: rediscache.NewHashCache(rd, apiKeyLastUsedCacheHashKey),
+		lastUsedFlusher:     newLastUsedFlusher(opts.LastUsedCacheSize, opts.LastUsedFlushInterval, opts.LastUsedFlushJitter),
+		negativeCache:       newNegativeCache(opts.NegativeCacheSize, opts.NegativeCacheTTL, opts.NegativeCacheJitter),
+		singleflightTimeout: opts.SingleflightTimeout,
 		logger:              logger,
 	}
+
+	// The last-used-at hash cache is a Redis-only optimization: adapters
+	// such as AdapterMemory and AdapterMemcached are documented to work
+	// without Redis, so it must stay optional instead of failing every
+	// Verify call when opts.Redis is unset.
+	if opts.Redis != nil {
+		v.apiKeyLastUsedCache = rediscache.NewHashCache(opts.Redis, apiKeyLastUsedCacheHashKey)
+	}
+
+	if opts.PersistentCacheFile != "" {
+		v.persistentCache = newPersistentCache(opts.PersistentCacheFile, opts.CacheTTL, opts.MaxCacheDuration, opts.PersistentCacheMaxEntries, logger)
+		// A missing, corrupt, or unreadable cache file must never fail
+		// startup: that would make piped strictly worse off than the
+		// thundering herd this cache exists to avoid. Warm-start empty
+		// and let the cache repopulate from the datastore instead.
+		entries, err := v.persistentCache.load(time.Now())
+		if err != nil {
+			logger.Warn("ignoring unusable persistent API key cache file, warm-starting empty", zap.Error(err))
+			entries = nil
+		}
+		for _, e := range entries {
+			if err := v.apiKeyCache.Put(e.KeyID, string(e.APIKey), opts.CacheTTL); err != nil {
+				logger.Warn("unable to warm API key cache from persistent cache entry", zap.String("api-key-id", e.KeyID), zap.Error(err))
+			}
+		}
+	}
+
+	return v, nil
+}
+
+// Close stops the background goroutines started by NewVerifier, such as the
+// persistent cache's flush loop. It does not flush pending writes.
+func (v *Verifier) Close() {
+	if v.persistentCache != nil {
+		v.persistentCache.Close()
+	}
+}
+
+// LastUsedCacheStats returns the cumulative hits/misses/flushes counters of
+// the in-process last-used flusher, for exposing as metrics.
+func (v *Verifier) LastUsedCacheStats() (hits, misses, flushes uint64) {
+	return v.lastUsedFlusher.stats()
+}
+
+// NegativeCacheStats returns the cumulative number of negative-cache hits
+// and coalesced (singleflight) datastore lookups, for exposing as metrics.
+func (v *Verifier) NegativeCacheStats() (negativeHits, coalesced uint64) {
+	return v.negativeCache.hitCount(), atomic.LoadUint64(&v.coalesced)
 }
 
 func (v *Verifier) Verify(ctx context.Context, key string) (*model.APIKey, error) {
@@ -57,34 +232,83 @@ func (v *Verifier) Verify(ctx context.Context, key string) (*model.APIKey, error
 		return nil, err
 	}
 
-	var apiKey *model.APIKey
-	item, err := v.apiKeyCache.Get(keyID)
-	if err == nil {
-		apiKey = item.(*model.APIKey)
-		if err := checkAPIKey(ctx, v, apiKey, keyID, key); err != nil {
+	if raw, err := v.apiKeyCache.Get(keyID); err == nil {
+		var apiKey model.APIKey
+		if err := json.Unmarshal([]byte(raw), &apiKey); err != nil {
+			return nil, fmt.Errorf("unable to decode cached API key %s: %w", keyID, err)
+		}
+		if err := checkAPIKey(ctx, v, &apiKey, keyID, key); err != nil {
 			return nil, err
 		}
-		return apiKey, nil
+		v.touchPersistentCache(keyID, &apiKey)
+		return &apiKey, nil
 	}
-	// If the cache data was not found,
-	// we have to retrieve from datastore and save it to the cache.
-	apiKey, err = v.apiKeyStore.Get(ctx, keyID)
+
+	if v.negativeCache.Contains(keyID, time.Now()) {
+		return nil, fmt.Errorf("unable to find API key %s from datastore, %w", keyID, datastore.ErrNotFound)
+	}
+
+	// If the cache data was not found, we have to retrieve it from the
+	// datastore and save it to the cache. Concurrent lookups for the same
+	// keyID are coalesced via singleflight so that a stampede of requests
+	// for a not-yet-cached key only hits the datastore once.
+	apiKey, err := v.getFromDatastore(ctx, keyID)
 	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			v.negativeCache.Add(keyID, time.Now())
+		}
 		return nil, fmt.Errorf("unable to find API key %s from datastore, %w", keyID, err)
 	}
 
-	// update the time API key was last used
-
-	if err := v.apiKeyCache.Put(keyID, apiKey); err != nil {
-		v.logger.Warn("unable to store API key in memory cache", zap.Error(err))
+	if raw, err := json.Marshal(apiKey); err != nil {
+		v.logger.Warn("unable to encode API key for caching", zap.Error(err))
+	} else if err := v.apiKeyCache.Put(keyID, string(raw), 0); err != nil {
+		v.logger.Warn("unable to store API key in cache", zap.Error(err))
 	}
 	if err := checkAPIKey(ctx, v, apiKey, keyID, key); err != nil {
 		return nil, err
 	}
+	v.touchPersistentCache(keyID, apiKey)
 
 	return apiKey, nil
 }
 
+// touchPersistentCache records keyID's successful verification in the
+// piped-side persistent cache, if one is configured.
+func (v *Verifier) touchPersistentCache(keyID string, apiKey *model.APIKey) {
+	if v.persistentCache == nil {
+		return
+	}
+	v.persistentCache.touch(keyID, apiKey, time.Now())
+}
+
+// getFromDatastore fetches keyID from the datastore, coalescing concurrent
+// calls for the same keyID into a single datastore lookup. The lookup runs
+// under its own singleflightTimeout deadline, independent of any individual
+// caller's ctx, so one coalesced caller can't cancel the datastore call for
+// the others; each caller still returns as soon as either the shared lookup
+// completes or its own ctx is done.
+func (v *Verifier) getFromDatastore(ctx context.Context, keyID string) (*model.APIKey, error) {
+	ch := v.sfGroup.DoChan(keyID, func() (interface{}, error) {
+		sfCtx, cancel := context.WithTimeout(context.Background(), v.singleflightTimeout)
+		defer cancel()
+		return v.apiKeyStore.Get(sfCtx, keyID)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Shared {
+			atomic.AddUint64(&v.coalesced, 1)
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*model.APIKey), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func checkAPIKey(ctx context.Context, v *Verifier, apiKey *model.APIKey, id, key string) error {
 	if apiKey.Disabled {
 		return fmt.Errorf("the api key %s was already disabled", id)
@@ -93,9 +317,21 @@ func checkAPIKey(ctx context.Context, v *Verifier, apiKey *model.APIKey, id, key
 	if err := apiKey.CompareKey(key); err != nil {
 		return fmt.Errorf("invalid api key %s: %w", id, err)
 	}
-	now := time.Now().Unix()
-	if err := v.apiKeyLastUsedCache.Put(id, now); err != nil {
-		return fmt.Errorf("unable to update the time API key %s was last used, %w", id, err)
+
+	// Only flush the last-used timestamp when the in-memory flusher says
+	// it's stale enough; this keeps a hot key from turning every single
+	// Verify call into a Redis (and eventually datastore) write.
+	now := time.Now()
+	if !v.lastUsedFlusher.shouldFlush(id, now) {
+		return nil
+	}
+	if v.apiKeyLastUsedCache != nil {
+		if err := v.apiKeyLastUsedCache.Put(id, now.Unix()); err != nil {
+			return fmt.Errorf("unable to update the time API key %s was last used, %w", id, err)
+		}
+	}
+	if err := v.apiKeyStore.UpdateLastUsedAt(ctx, id, apiKey.ProjectId); err != nil {
+		v.logger.Warn("unable to update the time API key was last used in datastore", zap.String("api-key-id", id), zap.Error(err))
 	}
 
 	return nil
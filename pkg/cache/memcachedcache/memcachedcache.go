@@ -0,0 +1,66 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memcachedcache implements a cache.StringCache backend on top of
+// memcached, for deployments that want to share an L2 tier across services
+// without running Redis.
+package memcachedcache
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"github.com/pipe-cd/pipecd/pkg/cache"
+)
+
+type stringCache struct {
+	client     *memcache.Client
+	keyPrefix  string
+	defaultTTL time.Duration
+}
+
+// NewStringCache returns a cache.StringCache backed by one or more
+// memcached servers. Every key is namespaced with keyPrefix. A Put call
+// with ttl <= 0 uses defaultTTL instead.
+func NewStringCache(addrs []string, keyPrefix string, defaultTTL time.Duration) cache.StringCache {
+	return &stringCache{
+		client:     memcache.New(addrs...),
+		keyPrefix:  keyPrefix,
+		defaultTTL: defaultTTL,
+	}
+}
+
+func (c *stringCache) fullKey(key string) string {
+	return c.keyPrefix + ":" + key
+}
+
+func (c *stringCache) Get(key string) (string, error) {
+	item, err := c.client.Get(c.fullKey(key))
+	if err != nil {
+		return "", cache.ErrStringCacheMiss
+	}
+	return string(item.Value), nil
+}
+
+func (c *stringCache) Put(key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+	return c.client.Set(&memcache.Item{
+		Key:        c.fullKey(key),
+		Value:      []byte(value),
+		Expiration: int32(ttl.Seconds()),
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memorycache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/pipe-cd/pipecd/pkg/cache"
+)
+
+// stringCache is a bounded, in-process implementation of cache.StringCache.
+// It is the nanosecond-latency L1 tier typically placed in front of a
+// shared L2 such as Redis or memcached.
+type stringCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+
+	maxEntries int
+	defaultTTL time.Duration
+}
+
+type stringCacheEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+// NewStringCache returns an in-memory cache.StringCache bounded to
+// maxEntries. A Put call with ttl <= 0 uses defaultTTL instead.
+func NewStringCache(maxEntries int, defaultTTL time.Duration) cache.StringCache {
+	return &stringCache{
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		defaultTTL: defaultTTL,
+	}
+}
+
+func (c *stringCache) Get(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return "", cache.ErrStringCacheMiss
+	}
+	entry := e.Value.(*stringCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return "", cache.ErrStringCacheMiss
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, nil
+}
+
+func (c *stringCache) Put(key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*stringCacheEntry)
+		entry.value = value
+		entry.expiresAt = time.Now().Add(ttl)
+		c.ll.MoveToFront(e)
+		return nil
+	}
+
+	e := c.ll.PushFront(&stringCacheEntry{
+		key:       key,
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	})
+	c.items[key] = e
+
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		if back := c.ll.Back(); back != nil {
+			c.ll.Remove(back)
+			delete(c.items, back.Value.(*stringCacheEntry).key)
+		}
+	}
+
+	return nil
+}
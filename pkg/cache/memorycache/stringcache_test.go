@@ -0,0 +1,63 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memorycache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pipe-cd/pipecd/pkg/cache"
+)
+
+func TestStringCacheGetMiss(t *testing.T) {
+	c := NewStringCache(10, time.Minute)
+
+	_, err := c.Get("key-1")
+	assert.ErrorIs(t, err, cache.ErrStringCacheMiss)
+}
+
+func TestStringCachePutGet(t *testing.T) {
+	c := NewStringCache(10, time.Minute)
+
+	assert.NoError(t, c.Put("key-1", "value-1", 0))
+
+	value, err := c.Get("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value-1", value)
+}
+
+func TestStringCacheExpiry(t *testing.T) {
+	c := NewStringCache(10, time.Millisecond)
+
+	assert.NoError(t, c.Put("key-1", "value-1", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	_, err := c.Get("key-1")
+	assert.ErrorIs(t, err, cache.ErrStringCacheMiss)
+}
+
+func TestStringCacheEvictsOldest(t *testing.T) {
+	c := NewStringCache(2, time.Minute).(*stringCache)
+
+	assert.NoError(t, c.Put("key-1", "value-1", 0))
+	assert.NoError(t, c.Put("key-2", "value-2", 0))
+	assert.NoError(t, c.Put("key-3", "value-3", 0))
+
+	assert.Equal(t, 2, c.ll.Len(), "cache should never grow past maxEntries")
+	_, err := c.Get("key-1")
+	assert.ErrorIs(t, err, cache.ErrStringCacheMiss, "the least recently used key should have been evicted")
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rediscache
+
+import (
+	"context"
+	"time"
+
+	"github.com/pipe-cd/pipecd/pkg/cache"
+	"github.com/pipe-cd/pipecd/pkg/redis"
+)
+
+// stringCache is a Redis-backed implementation of cache.StringCache, meant
+// to be used either standalone or as the shared L2 tier of a two-tier
+// cache.StringCache.
+type stringCache struct {
+	redis      redis.Redis
+	keyPrefix  string
+	defaultTTL time.Duration
+}
+
+// NewStringCache returns a cache.StringCache backed by Redis. Every key is
+// namespaced with keyPrefix. A Put call with ttl <= 0 uses defaultTTL
+// instead, and writes to Redis with SETEX so the entry expires on its own.
+func NewStringCache(rd redis.Redis, keyPrefix string, defaultTTL time.Duration) cache.StringCache {
+	return &stringCache{
+		redis:      rd,
+		keyPrefix:  keyPrefix,
+		defaultTTL: defaultTTL,
+	}
+}
+
+func (c *stringCache) fullKey(key string) string {
+	return c.keyPrefix + ":" + key
+}
+
+func (c *stringCache) Get(key string) (string, error) {
+	value, err := c.redis.Get(context.Background(), c.fullKey(key))
+	if err != nil {
+		return "", cache.ErrStringCacheMiss
+	}
+	return value, nil
+}
+
+func (c *stringCache) Put(key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+	return c.redis.Set(context.Background(), c.fullKey(key), value, ttl)
+}
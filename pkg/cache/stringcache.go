@@ -0,0 +1,86 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrStringCacheMiss is returned by StringCache.Get when the given key is
+// not present (or has expired).
+var ErrStringCacheMiss = errors.New("cache: key not found")
+
+// StringCache is a minimal, backend-agnostic abstraction over a
+// string-keyed, string-valued cache. It lets call sites pick an adapter
+// (in-memory, Redis, memcached, or a combined L1+L2 tier) without changing
+// how they read and write cache entries.
+type StringCache interface {
+	Get(key string) (string, error)
+	Put(key, value string, ttl time.Duration) error
+}
+
+// Adapter selects which backend a StringCache is built on.
+type Adapter string
+
+const (
+	AdapterMemory    Adapter = "memory"
+	AdapterRedis     Adapter = "redis"
+	AdapterMemcached Adapter = "memcached"
+	AdapterTwoTier   Adapter = "two-tier"
+)
+
+// twoTierCache combines a fast, local L1 with a shared L2. Reads check L1
+// first and fall back to L2, populating L1 on an L2 hit. Writes fan out to
+// both tiers so that other replicas sharing the same L2 observe the entry
+// too.
+type twoTierCache struct {
+	l1 StringCache
+	l2 StringCache
+}
+
+// NewTwoTierCache returns a StringCache that reads l1 first, falls through
+// to l2 on an l1 miss (populating l1 with the result), and fans writes out
+// to both tiers. l2 is expected to apply the given TTL itself (e.g. via
+// SETEX for a Redis-backed adapter).
+func NewTwoTierCache(l1, l2 StringCache) StringCache {
+	return &twoTierCache{l1: l1, l2: l2}
+}
+
+func (c *twoTierCache) Get(key string) (string, error) {
+	if value, err := c.l1.Get(key); err == nil {
+		return value, nil
+	}
+
+	value, err := c.l2.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	// Best-effort: a failure to warm L1 is not fatal, the value was still
+	// found in L2.
+	_ = c.l1.Put(key, value, 0)
+
+	return value, nil
+}
+
+func (c *twoTierCache) Put(key, value string, ttl time.Duration) error {
+	err1 := c.l1.Put(key, value, ttl)
+	err2 := c.l2.Put(key, value, ttl)
+	if err2 != nil {
+		return err2
+	}
+	return err1
+}
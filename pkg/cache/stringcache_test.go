@@ -0,0 +1,99 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStringCache is a minimal in-memory StringCache test double, used to
+// observe how twoTierCache drives its L1/L2 tiers without depending on the
+// memorycache package.
+type fakeStringCache struct {
+	values map[string]string
+}
+
+func newFakeStringCache() *fakeStringCache {
+	return &fakeStringCache{values: make(map[string]string)}
+}
+
+func (c *fakeStringCache) Get(key string) (string, error) {
+	v, ok := c.values[key]
+	if !ok {
+		return "", ErrStringCacheMiss
+	}
+	return v, nil
+}
+
+func (c *fakeStringCache) Put(key, value string, ttl time.Duration) error {
+	c.values[key] = value
+	return nil
+}
+
+func TestTwoTierCacheGetPopulatesL1OnL2Hit(t *testing.T) {
+	l1 := newFakeStringCache()
+	l2 := newFakeStringCache()
+	l2.values["key-1"] = "value-1"
+
+	c := NewTwoTierCache(l1, l2)
+
+	value, err := c.Get("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value-1", value)
+
+	l1Value, err := l1.Get("key-1")
+	assert.NoError(t, err, "an L2 hit should have populated L1")
+	assert.Equal(t, "value-1", l1Value)
+}
+
+func TestTwoTierCacheGetPrefersL1(t *testing.T) {
+	l1 := newFakeStringCache()
+	l2 := newFakeStringCache()
+	l1.values["key-1"] = "from-l1"
+	l2.values["key-1"] = "from-l2"
+
+	c := NewTwoTierCache(l1, l2)
+
+	value, err := c.Get("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-l1", value)
+}
+
+func TestTwoTierCacheGetMissOnBothTiers(t *testing.T) {
+	c := NewTwoTierCache(newFakeStringCache(), newFakeStringCache())
+
+	_, err := c.Get("key-1")
+	assert.ErrorIs(t, err, ErrStringCacheMiss)
+}
+
+func TestTwoTierCachePutFansOutToBothTiers(t *testing.T) {
+	l1 := newFakeStringCache()
+	l2 := newFakeStringCache()
+	c := NewTwoTierCache(l1, l2)
+
+	err := c.Put("key-1", "value-1", time.Minute)
+	assert.NoError(t, err)
+
+	v1, err := l1.Get("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value-1", v1)
+
+	v2, err := l2.Get("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value-1", v2)
+}
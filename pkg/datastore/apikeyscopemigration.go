@@ -0,0 +1,51 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pipe-cd/pipecd/pkg/model"
+)
+
+// APIKeyScopeMigrationStore is the subset of the API key store needed to
+// backfill scopes onto existing keys.
+type APIKeyScopeMigrationStore interface {
+	ListAPIKeys(ctx context.Context) ([]*model.APIKey, error)
+	UpdateAPIKeyPermissions(ctx context.Context, id string, permissions []model.Scope) error
+}
+
+// MigrateAPIKeyScopes backfills Scope_FULL_ACCESS onto every API key that
+// doesn't carry any Permissions yet, so keys created before scopes existed
+// keep working unchanged. It's meant to run once, e.g. from a control-plane
+// migration job, before scope checks are enforced in production.
+func MigrateAPIKeyScopes(ctx context.Context, store APIKeyScopeMigrationStore) error {
+	keys, err := store.ListAPIKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list API keys for scope migration: %w", err)
+	}
+
+	for _, k := range keys {
+		if len(k.Permissions) > 0 {
+			continue
+		}
+		if err := store.UpdateAPIKeyPermissions(ctx, k.Id, []model.Scope{model.Scope_FULL_ACCESS}); err != nil {
+			return fmt.Errorf("unable to backfill scope for API key %s: %w", k.Id, err)
+		}
+	}
+
+	return nil
+}
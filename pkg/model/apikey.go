@@ -0,0 +1,72 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// APIKey is the runtime/datastore model generated from apikey.proto.
+//
+// This checkout doesn't include the protoc toolchain or the rest of
+// pkg/model, so this file is a hand-maintained stand-in for the generated
+// apikey.pb.go; run `make generate` against apikey.proto to replace it with
+// the real generated code.
+type APIKey struct {
+	Id         string
+	ProjectId  string
+	KeyHash    string
+	Disabled   bool
+	CreatedAt  int64
+	UpdatedAt  int64
+	LastUsedAt int64
+
+	// Permissions lists the Scopes this key is allowed to use. An empty
+	// list is equivalent to carrying Scope_FULL_ACCESS, so a key created
+	// before scopes existed keeps working until the backfill migration
+	// (pkg/datastore/apikeyscopemigration.go) runs.
+	Permissions []Scope
+}
+
+// ErrInvalidAPIKey is returned when a raw API key string is malformed or
+// does not match the stored key hash.
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// ExtractAPIKeyID extracts the key ID from a raw "<id>.<secret>" API key.
+func ExtractAPIKeyID(key string) (string, error) {
+	id, _, ok := strings.Cut(key, ".")
+	if !ok || id == "" {
+		return "", ErrInvalidAPIKey
+	}
+	return id, nil
+}
+
+// CompareKey reports whether key's secret part matches the key's stored
+// hash.
+func (k *APIKey) CompareKey(key string) error {
+	_, secret, ok := strings.Cut(key, ".")
+	if !ok || secret == "" {
+		return ErrInvalidAPIKey
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if !hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(k.KeyHash)) {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// Scope represents a capability an API key is allowed to use. Each gRPC
+// method declares the Scope it requires (see pkg/rpc), so a key is no
+// longer all-or-nothing.
+type Scope string
+
+const (
+	Scope_READ_APPLICATION   Scope = "READ_APPLICATION"
+	Scope_TRIGGER_DEPLOYMENT Scope = "TRIGGER_DEPLOYMENT"
+	Scope_MANAGE_PIPED       Scope = "MANAGE_PIPED"
+	Scope_MANAGE_APIKEYS     Scope = "MANAGE_APIKEYS"
+	// Scope_FULL_ACCESS grants every scope. It's the scope assigned by the
+	// datastore migration to API keys created before scopes existed, so
+	// that existing keys keep working unchanged.
+	Scope_FULL_ACCESS Scope = "FULL_ACCESS"
+)
+
+// HasScope reports whether the API key is allowed to use the given scope.
+// A key with no Permissions (or with Scope_FULL_ACCESS among them) is
+// allowed to use any scope.
+func (k *APIKey) HasScope(scope Scope) bool {
+	if len(k.Permissions) == 0 {
+		return true
+	}
+	for _, s := range k.Permissions {
+		if s == Scope_FULL_ACCESS || s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpcauth holds the credential-extraction helpers shared by every
+// gRPC auth interceptor, so each one parses the "authorization" metadata
+// the same way instead of re-implementing it.
+package rpcauth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	credentialMetadataKey = "authorization"
+	bearerScheme          = "Bearer "
+)
+
+// ExtractAPIKey extracts the API key from the incoming gRPC request's
+// "authorization" metadata. piped and API clients send it as a
+// Bearer-scheme credential.
+func ExtractAPIKey(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "missing metadata in request")
+	}
+	values := md.Get(credentialMetadataKey)
+	if len(values) == 0 {
+		return "", status.Error(codes.Unauthenticated, "missing credentials in request")
+	}
+	cred := values[0]
+	if !strings.HasPrefix(cred, bearerScheme) {
+		return "", status.Error(codes.Unauthenticated, "credential is not a bearer token")
+	}
+	return strings.TrimPrefix(cred, bearerScheme), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pipe-cd/pipecd/pkg/app/server/apikeyverifier"
+	"github.com/pipe-cd/pipecd/pkg/model"
+	"github.com/pipe-cd/pipecd/pkg/rpc/rpcauth"
+)
+
+// methodScopes maps a fully-qualified gRPC method name
+// (e.g. "/grpc.service.PipedAPIService/ReportDeploymentPlanned") to the
+// Scope an API key must carry to call it.
+var methodScopes = make(map[string]model.Scope)
+
+// publicMethods lists fully-qualified gRPC method names that intentionally
+// require no API key scope (e.g. health checks). A method must be
+// registered here or in methodScopes; see the fail-closed default in
+// APIKeyScopeUnaryServerInterceptor below.
+var publicMethods = make(map[string]struct{})
+
+// RegisterMethodScope declares that method requires scope. Services call
+// this from their init() so new endpoints declare their scope requirement
+// declaratively, next to where the method is implemented, instead of in a
+// central switch statement.
+func RegisterMethodScope(method string, scope model.Scope) {
+	methodScopes[method] = scope
+}
+
+// RegisterPublicMethod declares that method is intentionally exempt from
+// API key scope enforcement. Use this for the rare endpoint that must stay
+// reachable without a scoped API key (e.g. a health check); everything else
+// should call RegisterMethodScope instead.
+func RegisterPublicMethod(method string) {
+	publicMethods[method] = struct{}{}
+}
+
+// APIKeyScopeUnaryServerInterceptor rejects a unary call whose API key does
+// not carry the Scope registered for the called method via
+// RegisterMethodScope. It fails closed: a method that is neither registered
+// via RegisterMethodScope nor explicitly exempted via RegisterPublicMethod
+// is rejected, so a missing or mistyped registration can never silently
+// leave an endpoint unprotected.
+func APIKeyScopeUnaryServerInterceptor(verifier *apikeyverifier.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := publicMethods[info.FullMethod]; ok {
+			return handler(ctx, req)
+		}
+
+		scope, ok := methodScopes[info.FullMethod]
+		if !ok {
+			return nil, status.Errorf(codes.PermissionDenied, "method %s has no registered API key scope", info.FullMethod)
+		}
+
+		key, err := rpcauth.ExtractAPIKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := verifier.VerifyWithScope(ctx, key, scope); err != nil {
+			return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The PipeCD Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/pipe-cd/pipecd/pkg/app/server/apikeyverifier"
+	"github.com/pipe-cd/pipecd/pkg/model"
+)
+
+type fakeAPIKeyGetter struct {
+	key *model.APIKey
+}
+
+func (g *fakeAPIKeyGetter) Get(ctx context.Context, id string) (*model.APIKey, error) {
+	if g.key == nil || g.key.Id != id {
+		return nil, assert.AnError
+	}
+	return g.key, nil
+}
+
+func (g *fakeAPIKeyGetter) UpdateLastUsedAt(ctx context.Context, id, projectID string) error {
+	return nil
+}
+
+func newTestVerifier(t *testing.T, key *model.APIKey) *apikeyverifier.Verifier {
+	t.Helper()
+	v, err := apikeyverifier.NewVerifier(context.Background(), &fakeAPIKeyGetter{key: key}, zap.NewNop(), apikeyverifier.DefaultVerifierOptions())
+	require.NoError(t, err)
+	t.Cleanup(v.Close)
+	return v
+}
+
+func newTestAPIKey(id, secret string, permissions ...model.Scope) *model.APIKey {
+	sum := sha256.Sum256([]byte(secret))
+	return &model.APIKey{
+		Id:          id,
+		KeyHash:     hex.EncodeToString(sum[:]),
+		Permissions: permissions,
+	}
+}
+
+func callWithAPIKey(ctx context.Context, interceptor grpc.UnaryServerInterceptor, method, apiKey string) (bool, error) {
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+apiKey))
+	handlerCalled := false
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return nil, nil
+	})
+	return handlerCalled, err
+}
+
+func TestAPIKeyScopeUnaryServerInterceptorAllowsSufficientScope(t *testing.T) {
+	const method = "/pipecd.test.Interceptor/Allows"
+	RegisterMethodScope(method, model.Scope_READ_APPLICATION)
+
+	key := newTestAPIKey("key-1", "secret1", model.Scope_READ_APPLICATION)
+	interceptor := APIKeyScopeUnaryServerInterceptor(newTestVerifier(t, key))
+
+	called, err := callWithAPIKey(context.Background(), interceptor, method, "key-1.secret1")
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
+
+func TestAPIKeyScopeUnaryServerInterceptorRejectsInsufficientScope(t *testing.T) {
+	const method = "/pipecd.test.Interceptor/Rejects"
+	RegisterMethodScope(method, model.Scope_MANAGE_APIKEYS)
+
+	key := newTestAPIKey("key-1", "secret1", model.Scope_READ_APPLICATION)
+	interceptor := APIKeyScopeUnaryServerInterceptor(newTestVerifier(t, key))
+
+	called, err := callWithAPIKey(context.Background(), interceptor, method, "key-1.secret1")
+	assert.False(t, called)
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAPIKeyScopeUnaryServerInterceptorDeniesUnregisteredMethod(t *testing.T) {
+	const method = "/pipecd.test.Interceptor/Unregistered"
+
+	key := newTestAPIKey("key-1", "secret1")
+	interceptor := APIKeyScopeUnaryServerInterceptor(newTestVerifier(t, key))
+
+	called, err := callWithAPIKey(context.Background(), interceptor, method, "key-1.secret1")
+	assert.False(t, called, "a method with no registered scope must fail closed, not be let through")
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAPIKeyScopeUnaryServerInterceptorAllowsPublicMethod(t *testing.T) {
+	const method = "/pipecd.test.Interceptor/Public"
+	RegisterPublicMethod(method)
+
+	interceptor := APIKeyScopeUnaryServerInterceptor(newTestVerifier(t, nil))
+
+	handlerCalled := false
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, handlerCalled, "a registered public method should bypass scope enforcement entirely, with no API key required")
+}